@@ -0,0 +1,435 @@
+package deploy
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+	kcmdutil "k8s.io/kubernetes/pkg/kubectl/cmd/util"
+	"k8s.io/kubernetes/pkg/kubectl/genericclioptions"
+	"k8s.io/kubernetes/pkg/kubectl/util/templates"
+
+	appsv1 "github.com/openshift/api/apps/v1"
+	appsclient "github.com/openshift/client-go/apps/clientset/versioned/typed/apps/v1"
+	appsapi "github.com/openshift/origin/pkg/apps/apis/apps"
+	appsutil "github.com/openshift/origin/pkg/apps/util"
+)
+
+var (
+	deployLong = templates.LongDesc(`
+		View, start, cancel, retry, or roll back a deployment
+
+		This command allows you to control a deployment config. Each individual deployment is exposed
+		as a new replication controller, and the deployment process manages scaling down old deployments
+		and scaling up new ones. Use '%[1]s rollout status' to check the status of the latest deployment.`)
+
+	deployExample = templates.Examples(`
+		# Display the status of the latest deployment for the 'database' deployment config
+		%[1]s deploy database
+
+		# Start a new deployment based on the 'database' deployment config
+		%[1]s deploy database --latest
+
+		# Retry the latest failed deployment for 'database'
+		%[1]s deploy database --retry
+
+		# Cancel the in-progress deployment for 'database'
+		%[1]s deploy database --cancel
+
+		# Roll 'database' back to deployment #3, without actually making the change
+		%[1]s deploy database --to-revision=3 --dry-run`)
+)
+
+// DeployOptions holds values for the `deploy` subcommand.
+type DeployOptions struct {
+	Namespace        string
+	DeploymentConfig string
+	Latest           bool
+	Retry            bool
+	Cancel           bool
+	EnableTriggers   bool
+	Rollback         bool
+	ToRevision       int64
+	DryRun           bool
+	History          bool
+	Output           string
+
+	appsClient appsclient.AppsV1Interface
+	kubeClient kubernetes.Interface
+
+	genericclioptions.IOStreams
+}
+
+// NewDeployOptions returns a DeployOptions with its IOStreams set.
+func NewDeployOptions(streams genericclioptions.IOStreams) *DeployOptions {
+	return &DeployOptions{IOStreams: streams}
+}
+
+// NewCmdDeploy creates the `deploy` command.
+func NewCmdDeploy(fullName string, f kcmdutil.Factory, streams genericclioptions.IOStreams) *cobra.Command {
+	o := NewDeployOptions(streams)
+	cmd := &cobra.Command{
+		Use:     "deploy DEPLOYMENTCONFIG",
+		Short:   "View, start, cancel, retry, or roll back a deployment",
+		Long:    fmt.Sprintf(deployLong, fullName),
+		Example: fmt.Sprintf(deployExample, fullName),
+		Run: func(cmd *cobra.Command, args []string) {
+			kcmdutil.CheckErr(o.Complete(f, cmd, args))
+			kcmdutil.CheckErr(o.Validate())
+			kcmdutil.CheckErr(o.RunDeploy())
+		},
+	}
+	cmd.Flags().BoolVar(&o.Latest, "latest", o.Latest, "Start a new deployment now.")
+	cmd.Flags().BoolVar(&o.Retry, "retry", o.Retry, "Retry the latest failed deployment.")
+	cmd.Flags().BoolVar(&o.Cancel, "cancel", o.Cancel, "Cancel the in-progress deployment.")
+	cmd.Flags().BoolVar(&o.EnableTriggers, "enable-triggers", o.EnableTriggers, "Enable all image triggers for the deployment config.")
+	cmd.Flags().Int64Var(&o.ToRevision, "to-revision", o.ToRevision, "Roll back to the specified deployment revision. Requires --to-revision to be greater than zero.")
+	cmd.Flags().BoolVar(&o.DryRun, "dry-run", o.DryRun, "Print the result of the rollback without performing it.")
+	cmd.Flags().BoolVar(&o.History, "history", o.History, "Display the revision history for the deployment config.")
+	cmd.Flags().StringVarP(&o.Output, "output", "o", o.Output, "Output format for --history. One of: json|yaml.")
+	return cmd
+}
+
+// Complete turns positional args and flags into usable values.
+func (o *DeployOptions) Complete(f kcmdutil.Factory, cmd *cobra.Command, args []string) error {
+	if len(args) != 1 {
+		return kcmdutil.UsageErrorf(cmd, "a deployment config name is required")
+	}
+	o.DeploymentConfig = args[0]
+	o.Rollback = o.ToRevision > 0
+
+	namespace, _, err := f.ToRawKubeConfigLoader().Namespace()
+	if err != nil {
+		return err
+	}
+	o.Namespace = namespace
+
+	clientConfig, err := f.ToRESTConfig()
+	if err != nil {
+		return err
+	}
+	o.appsClient, err = appsclient.NewForConfig(clientConfig)
+	if err != nil {
+		return err
+	}
+	o.kubeClient, err = kubernetes.NewForConfig(clientConfig)
+	return err
+}
+
+// Validate ensures that at most one deployment action was requested.
+func (o *DeployOptions) Validate() error {
+	count := 0
+	for _, set := range []bool{o.Latest, o.Retry, o.Cancel, o.EnableTriggers, o.Rollback, o.History} {
+		if set {
+			count++
+		}
+	}
+	if count > 1 {
+		return fmt.Errorf("only one of --latest, --retry, --cancel, --enable-triggers, --to-revision, or --history may be specified")
+	}
+	if o.Output != "" && o.Output != "json" && o.Output != "yaml" {
+		return fmt.Errorf("invalid output format %q; must be one of: json, yaml", o.Output)
+	}
+	return nil
+}
+
+// RunDeploy dispatches to the requested deployment action.
+func (o *DeployOptions) RunDeploy() error {
+	config, err := o.appsClient.DeploymentConfigs(o.Namespace).Get(o.DeploymentConfig, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case o.Latest:
+		return o.deploy(config)
+	case o.Retry:
+		return o.retry(config)
+	case o.Cancel:
+		return o.cancel(config)
+	case o.EnableTriggers:
+		return o.reenableTriggers(config)
+	case o.Rollback:
+		return o.rollback(config)
+	case o.History:
+		return o.history(config)
+	default:
+		return o.describe(config)
+	}
+}
+
+func (o *DeployOptions) describe(config *appsv1.DeploymentConfig) error {
+	fmt.Fprintf(o.Out, "%s is at deployment #%d\n", config.Name, config.Status.LatestVersion)
+	return nil
+}
+
+// deploy starts a new deployment for config, rejecting the request if a
+// deployment is already in progress. It also runs the strategy's
+// pre-deployment hook itself, the same way the deployer would, so its
+// failure is reported here rather than only surfacing later as a failed
+// deployment; mid and post hooks run during the deployment process itself
+// and are left to the deployer, as for any other deployment trigger.
+func (o *DeployOptions) deploy(config *appsv1.DeploymentConfig) error {
+	existing, err := o.kubeClient.CoreV1().ReplicationControllers(config.Namespace).Get(appsutil.LatestDeploymentNameForConfig(config), metav1.GetOptions{})
+	switch {
+	case err == nil:
+		switch status := appsutil.DeploymentStatusFor(existing); status {
+		case appsutil.DeploymentStatusNew, appsutil.DeploymentStatusPending, appsutil.DeploymentStatusRunning:
+			return fmt.Errorf("#%d is already in progress (%s).", appsutil.DeploymentVersionFor(existing), status)
+		}
+	case kerrors.IsNotFound(err):
+		// no previous deployment, nothing to check
+	default:
+		return err
+	}
+
+	request := &appsv1.DeploymentRequest{
+		Name:   config.Name,
+		Latest: true,
+		Force:  true,
+	}
+	updated, err := o.appsClient.DeploymentConfigs(config.Namespace).Instantiate(config.Name, request)
+	if err != nil {
+		return err
+	}
+
+	if hook := preHookFor(updated); hook != nil {
+		deployment, err := appsutil.MakeDeployment(updated)
+		if err != nil {
+			return err
+		}
+		executor := NewHookExecutor(o.kubeClient, o.Out)
+		if err := executor.Execute(hook, deployment, "pre"); err != nil {
+			return err
+		}
+	}
+
+	fmt.Fprintf(o.Out, "Started deployment #%d\n", updated.Status.LatestVersion)
+	return nil
+}
+
+// preHookFor returns config's pre-deployment lifecycle hook, if its strategy
+// defines one. Only Pre is returned; Mid and Post hooks are run by the
+// deployer as it rolls the new deployment out, not by this command.
+func preHookFor(config *appsv1.DeploymentConfig) *appsv1.LifecycleHook {
+	switch {
+	case config.Spec.Strategy.RecreateParams != nil:
+		return config.Spec.Strategy.RecreateParams.Pre
+	case config.Spec.Strategy.RollingParams != nil:
+		return config.Spec.Strategy.RollingParams.Pre
+	default:
+		return nil
+	}
+}
+
+// retry resets a failed deployment so it will be retried, deleting any
+// deployer and hook pods left over from the failed attempt.
+func (o *DeployOptions) retry(config *appsv1.DeploymentConfig) error {
+	deployment, err := o.kubeClient.CoreV1().ReplicationControllers(config.Namespace).Get(appsutil.LatestDeploymentNameForConfig(config), metav1.GetOptions{})
+	if err != nil {
+		if kerrors.IsNotFound(err) {
+			return fmt.Errorf("no deployments found for %q", config.Name)
+		}
+		return err
+	}
+
+	status := appsutil.DeploymentStatusFor(deployment)
+	if status != appsutil.DeploymentStatusFailed {
+		return fmt.Errorf("#%d is %s; only failed deployments can be retried", appsutil.DeploymentVersionFor(deployment), status)
+	}
+
+	if err := o.deleteDeployerPods(deployment); err != nil {
+		return err
+	}
+
+	name := deployment.Name
+	err = retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		deployment, err := o.kubeClient.CoreV1().ReplicationControllers(config.Namespace).Get(name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		delete(deployment.Annotations, appsapi.DeploymentCancelledAnnotation)
+		delete(deployment.Annotations, appsapi.DeploymentStatusReasonAnnotation)
+		deployment.Annotations[appsapi.DeploymentStatusAnnotation] = string(appsutil.DeploymentStatusNew)
+		_, err = o.kubeClient.CoreV1().ReplicationControllers(deployment.Namespace).Update(deployment)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(o.Out, "Retried #%d\n", appsutil.DeploymentVersionFor(deployment))
+	return nil
+}
+
+// deleteDeployerPods removes the deployer and lifecycle hook pods associated
+// with deployment.
+func (o *DeployOptions) deleteDeployerPods(deployment *corev1.ReplicationController) error {
+	pods, err := o.kubeClient.CoreV1().Pods(deployment.Namespace).List(metav1.ListOptions{
+		LabelSelector: appsutil.DeployerPodSelector(deployment.Name).String(),
+	})
+	if err != nil {
+		return err
+	}
+	for _, pod := range pods.Items {
+		err := o.kubeClient.CoreV1().Pods(pod.Namespace).Delete(pod.Name, &metav1.DeleteOptions{})
+		if err != nil && !kerrors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// cancel stops any in-progress deployments for config.
+func (o *DeployOptions) cancel(config *appsv1.DeploymentConfig) error {
+	deployments, err := o.kubeClient.CoreV1().ReplicationControllers(config.Namespace).List(metav1.ListOptions{
+		LabelSelector: appsutil.ConfigSelector(config.Name).String(),
+	})
+	if err != nil {
+		return err
+	}
+
+	cancelled := 0
+	for i := range deployments.Items {
+		deployment := &deployments.Items[i]
+		switch appsutil.DeploymentStatusFor(deployment) {
+		case appsutil.DeploymentStatusNew, appsutil.DeploymentStatusPending, appsutil.DeploymentStatusRunning:
+		default:
+			continue
+		}
+
+		name := deployment.Name
+		err := retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+			deployment, err := o.kubeClient.CoreV1().ReplicationControllers(config.Namespace).Get(name, metav1.GetOptions{})
+			if err != nil {
+				return err
+			}
+			deployment.Annotations[appsapi.DeploymentCancelledAnnotation] = appsapi.DeploymentCancelledAnnotationValue
+			deployment.Annotations[appsapi.DeploymentStatusReasonAnnotation] = appsapi.DeploymentCancelledByUser
+			_, err = o.kubeClient.CoreV1().ReplicationControllers(deployment.Namespace).Update(deployment)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(o.Out, "Cancelled deployment #%d\n", appsutil.DeploymentVersionFor(deployment))
+		cancelled++
+	}
+
+	if cancelled == 0 {
+		fmt.Fprintln(o.Out, "no deployments are in progress")
+	}
+	return nil
+}
+
+// reenableTriggers turns automatic image-change triggers back on for config.
+func (o *DeployOptions) reenableTriggers(config *appsv1.DeploymentConfig) error {
+	enabled := 0
+	for _, trigger := range config.Spec.Triggers {
+		if trigger.Type == appsv1.DeploymentTriggerOnImageChange && trigger.ImageChangeParams != nil {
+			enabled++
+		}
+	}
+	if enabled == 0 {
+		return nil
+	}
+
+	name := config.Name
+	err := retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		config, err := o.appsClient.DeploymentConfigs(config.Namespace).Get(name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		for _, trigger := range config.Spec.Triggers {
+			if trigger.Type == appsv1.DeploymentTriggerOnImageChange && trigger.ImageChangeParams != nil {
+				trigger.ImageChangeParams.Automatic = true
+			}
+		}
+		_, err = o.appsClient.DeploymentConfigs(config.Namespace).Update(config)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(o.Out, "Enabled image triggers for %s\n", name)
+	return nil
+}
+
+// deploymentForVersion returns the ReplicationController owned by config whose
+// revision matches version.
+func (o *DeployOptions) deploymentForVersion(config *appsv1.DeploymentConfig, version int64) (*corev1.ReplicationController, error) {
+	deployments, err := o.kubeClient.CoreV1().ReplicationControllers(config.Namespace).List(metav1.ListOptions{
+		LabelSelector: appsutil.ConfigSelector(config.Name).String(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i := range deployments.Items {
+		if appsutil.DeploymentVersionFor(&deployments.Items[i]) == version {
+			return &deployments.Items[i], nil
+		}
+	}
+	return nil, fmt.Errorf("couldn't find deployment #%d for %q", version, config.Name)
+}
+
+// rollback reverts config's pod spec to the one recorded in the
+// ReplicationController for o.ToRevision, disables image triggers so the
+// rollback sticks, and instantiates a new deployment from it.
+//
+// The currently-active RC is scaled down by the deployer for that new
+// deployment, exactly as it would be for any other deployment transition,
+// rather than by this command zeroing its replicas directly: doing that here
+// would create a window with no RC serving traffic at all if the new
+// deployment never came up, whereas the deployer only scales the old RC down
+// once the new one is ready.
+func (o *DeployOptions) rollback(config *appsv1.DeploymentConfig) error {
+	target, err := o.deploymentForVersion(config, o.ToRevision)
+	if err != nil {
+		return err
+	}
+	if status := appsutil.DeploymentStatusFor(target); status == appsutil.DeploymentStatusFailed {
+		return fmt.Errorf("cannot roll back to #%d: that deployment failed", o.ToRevision)
+	}
+
+	if o.DryRun {
+		fmt.Fprintf(o.Out, "Dry run: %s would be rolled back to deployment #%d\n", config.Name, o.ToRevision)
+		return nil
+	}
+
+	rolledBack := config.DeepCopy()
+	rolledBack.Spec.Template.Spec = *target.Spec.Template.Spec.DeepCopy()
+	disableImageTriggers(rolledBack)
+
+	if _, err := o.appsClient.DeploymentConfigs(config.Namespace).Update(rolledBack); err != nil {
+		return err
+	}
+
+	request := &appsv1.DeploymentRequest{
+		Name:   config.Name,
+		Latest: true,
+		Force:  true,
+	}
+	if _, err := o.appsClient.DeploymentConfigs(config.Namespace).Instantiate(config.Name, request); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(o.Out, "Rolled back %s to deployment #%d\n", config.Name, o.ToRevision)
+	return nil
+}
+
+// disableImageTriggers turns off automatic image-change triggers on config,
+// mirroring reenableTriggers, so a rollback isn't immediately undone by the
+// next image push.
+func disableImageTriggers(config *appsv1.DeploymentConfig) {
+	for _, trigger := range config.Spec.Triggers {
+		if trigger.Type == appsv1.DeploymentTriggerOnImageChange && trigger.ImageChangeParams != nil {
+			trigger.ImageChangeParams.Automatic = false
+		}
+	}
+}
@@ -4,12 +4,15 @@ import (
 	"fmt"
 	"reflect"
 	"sort"
+	"strings"
 	"testing"
 
 	corev1 "k8s.io/api/core/v1"
 	kerrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
 	kubefake "k8s.io/client-go/kubernetes/fake"
 	clientgotesting "k8s.io/client-go/testing"
 
@@ -257,6 +260,15 @@ func TestCmdDeploy_cancelOk(t *testing.T) {
 		kubeClient.PrependReactor("list", "replicationcontrollers", func(action clientgotesting.Action) (handled bool, ret runtime.Object, err error) {
 			return true, existingDeployments, nil
 		})
+		kubeClient.PrependReactor("get", "replicationcontrollers", func(action clientgotesting.Action) (handled bool, ret runtime.Object, err error) {
+			name := action.(clientgotesting.GetAction).GetName()
+			for i := range existingDeployments.Items {
+				if existingDeployments.Items[i].Name == name {
+					return true, &existingDeployments.Items[i], nil
+				}
+			}
+			return true, nil, kerrors.NewNotFound(corev1.Resource("replicationcontrollers"), name)
+		})
 
 		o := &DeployOptions{kubeClient: kubeClient, IOStreams: genericclioptions.NewTestIOStreamsDiscard()}
 
@@ -299,12 +311,6 @@ func TestDeploy_reenableTriggers(t *testing.T) {
 
 	var updated *appsv1.DeploymentConfig
 
-	osClient := &appsfake.Clientset{}
-	osClient.AddReactor("update", "deploymentconfigs", func(action clientgotesting.Action) (handled bool, ret runtime.Object, err error) {
-		updated = action.(clientgotesting.UpdateAction).GetObject().(*appsv1.DeploymentConfig)
-		return true, updated, nil
-	})
-
 	config := appstest.OkDeploymentConfig(1)
 	config.Spec.Triggers = []appsv1.DeploymentTriggerPolicy{}
 	count := 3
@@ -312,6 +318,15 @@ func TestDeploy_reenableTriggers(t *testing.T) {
 		config.Spec.Triggers = append(config.Spec.Triggers, mktrigger())
 	}
 
+	osClient := &appsfake.Clientset{}
+	osClient.AddReactor("get", "deploymentconfigs", func(action clientgotesting.Action) (handled bool, ret runtime.Object, err error) {
+		return true, config, nil
+	})
+	osClient.AddReactor("update", "deploymentconfigs", func(action clientgotesting.Action) (handled bool, ret runtime.Object, err error) {
+		updated = action.(clientgotesting.UpdateAction).GetObject().(*appsv1.DeploymentConfig)
+		return true, updated, nil
+	})
+
 	o := &DeployOptions{appsClient: osClient.Apps(), IOStreams: genericclioptions.NewTestIOStreamsDiscard()}
 	err := o.reenableTriggers(config)
 	if err != nil {
@@ -331,3 +346,433 @@ func TestDeploy_reenableTriggers(t *testing.T) {
 		}
 	}
 }
+
+// TestCmdDeploy_rollbackOk ensures that a rollback to an older, complete
+// revision copies that revision's pod spec onto the config, preserving the
+// config's own template metadata, disables image triggers, and instantiates
+// a new deployment rather than touching the active RC directly. Scaling the
+// previously-active RC down is the new deployment's deployer's job, the same
+// as for any other deployment transition, so this test fails if rollback
+// updates a ReplicationController itself instead of leaving that to the
+// deployer.
+func TestCmdDeploy_rollbackOk(t *testing.T) {
+	config := appstest.OkDeploymentConfig(2)
+	config.Spec.Triggers = []appsv1.DeploymentTriggerPolicy{appstest.OkImageChangeTrigger()}
+	config.Spec.Template.ObjectMeta.Labels = map[string]string{"app": "database"}
+
+	older := appstest.OkDeploymentConfig(1)
+	older.Spec.Template.Spec.Containers[0].Image = "test/older:v1"
+	targetDeployment := deploymentFor(older, appsutil.DeploymentStatusComplete)
+
+	active := deploymentFor(config, appsutil.DeploymentStatusComplete)
+
+	var updatedConfig *appsv1.DeploymentConfig
+	var instantiated bool
+
+	osClient := &appsfake.Clientset{}
+	osClient.PrependReactor("update", "deploymentconfigs", func(action clientgotesting.Action) (handled bool, ret runtime.Object, err error) {
+		updatedConfig = action.(clientgotesting.UpdateAction).GetObject().(*appsv1.DeploymentConfig)
+		return true, updatedConfig, nil
+	})
+	osClient.PrependReactor("create", "deploymentconfigs", func(action clientgotesting.Action) (handled bool, ret runtime.Object, err error) {
+		if action.GetSubresource() != "instantiate" {
+			return false, nil, nil
+		}
+		instantiated = true
+		return true, updatedConfig, nil
+	})
+
+	kubeClient := kubefake.NewSimpleClientset()
+	kubeClient.PrependReactor("list", "replicationcontrollers", func(action clientgotesting.Action) (handled bool, ret runtime.Object, err error) {
+		return true, &corev1.ReplicationControllerList{Items: []corev1.ReplicationController{*targetDeployment, *active}}, nil
+	})
+	kubeClient.PrependReactor("update", "replicationcontrollers", func(action clientgotesting.Action) (handled bool, ret runtime.Object, err error) {
+		t.Fatalf("rollback must not update any ReplicationController directly; the deployer scales the old one down")
+		return false, nil, nil
+	})
+
+	o := &DeployOptions{appsClient: osClient.Apps(), kubeClient: kubeClient, ToRevision: 1, IOStreams: genericclioptions.NewTestIOStreamsDiscard()}
+	if err := o.rollback(config); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if updatedConfig == nil {
+		t.Fatalf("expected an updated config")
+	}
+	if !instantiated {
+		t.Fatalf("expected rollback to instantiate a new deployment")
+	}
+	if e, a := older.Spec.Template.Spec.Containers[0].Image, updatedConfig.Spec.Template.Spec.Containers[0].Image; e != a {
+		t.Fatalf("expected pod spec copied from revision #1, got image %s", a)
+	}
+	if e, a := "database", updatedConfig.Spec.Template.ObjectMeta.Labels["app"]; e != a {
+		t.Fatalf("expected the config's own template metadata to be preserved, got labels %#v", updatedConfig.Spec.Template.ObjectMeta.Labels)
+	}
+	for _, trigger := range updatedConfig.Spec.Triggers {
+		if trigger.ImageChangeParams.Automatic {
+			t.Errorf("expected image trigger to be disabled after rollback: %#v", trigger.ImageChangeParams)
+		}
+	}
+}
+
+// TestCmdDeploy_rollbackRejectMissingRevision ensures that rollback is
+// rejected when the target revision doesn't exist or is itself failed.
+func TestCmdDeploy_rollbackRejectMissingRevision(t *testing.T) {
+	config := appstest.OkDeploymentConfig(2)
+	failedOlder := appstest.OkDeploymentConfig(1)
+	failedDeployment := deploymentFor(failedOlder, appsutil.DeploymentStatusFailed)
+
+	cases := []struct {
+		name        string
+		toRevision  int64
+		deployments []corev1.ReplicationController
+	}{
+		{"missing revision", 5, []corev1.ReplicationController{*failedDeployment}},
+		{"failed revision", 1, []corev1.ReplicationController{*failedDeployment}},
+	}
+
+	for _, c := range cases {
+		kubeClient := kubefake.NewSimpleClientset()
+		kubeClient.PrependReactor("list", "replicationcontrollers", func(action clientgotesting.Action) (handled bool, ret runtime.Object, err error) {
+			return true, &corev1.ReplicationControllerList{Items: c.deployments}, nil
+		})
+
+		o := &DeployOptions{kubeClient: kubeClient, ToRevision: c.toRevision, IOStreams: genericclioptions.NewTestIOStreamsDiscard()}
+		if err := o.rollback(config); err == nil {
+			t.Errorf("%s: expected an error rolling back to revision %d", c.name, c.toRevision)
+		}
+	}
+}
+
+// TestCmdDeploy_retryConflictRetried ensures that retry re-fetches and
+// re-applies its mutation when it races a conflicting update, rather than
+// surfacing the conflict to the user.
+func TestCmdDeploy_retryConflictRetried(t *testing.T) {
+	config := appstest.OkDeploymentConfig(1)
+	existingDeployment := deploymentFor(config, appsutil.DeploymentStatusFailed)
+
+	attempts := 0
+	kubeClient := kubefake.NewSimpleClientset()
+	kubeClient.PrependReactor("get", "replicationcontrollers", func(action clientgotesting.Action) (handled bool, ret runtime.Object, err error) {
+		return true, existingDeployment, nil
+	})
+	kubeClient.PrependReactor("list", "pods", func(action clientgotesting.Action) (handled bool, ret runtime.Object, err error) {
+		return true, &corev1.PodList{}, nil
+	})
+	kubeClient.PrependReactor("update", "replicationcontrollers", func(action clientgotesting.Action) (handled bool, ret runtime.Object, err error) {
+		attempts++
+		if attempts < 3 {
+			return true, nil, kerrors.NewConflict(corev1.Resource("replicationcontrollers"), existingDeployment.Name, fmt.Errorf("conflict"))
+		}
+		return true, action.(clientgotesting.UpdateAction).GetObject(), nil
+	})
+
+	o := &DeployOptions{kubeClient: kubeClient, IOStreams: genericclioptions.NewTestIOStreamsDiscard()}
+	if err := o.retry(config); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if attempts != 3 {
+		t.Fatalf("expected 3 update attempts, got %d", attempts)
+	}
+}
+
+// TestCmdDeploy_cancelConflictRetried mirrors TestCmdDeploy_retryConflictRetried
+// for the cancel path.
+func TestCmdDeploy_cancelConflictRetried(t *testing.T) {
+	config := appstest.OkDeploymentConfig(1)
+	existingDeployment, _ := appsutil.MakeDeployment(config)
+	existingDeployment.Annotations[appsapi.DeploymentStatusAnnotation] = string(appsutil.DeploymentStatusRunning)
+	existingDeployments := &corev1.ReplicationControllerList{Items: []corev1.ReplicationController{*existingDeployment}}
+
+	attempts := 0
+	kubeClient := kubefake.NewSimpleClientset()
+	kubeClient.PrependReactor("list", "replicationcontrollers", func(action clientgotesting.Action) (handled bool, ret runtime.Object, err error) {
+		return true, existingDeployments, nil
+	})
+	kubeClient.PrependReactor("get", "replicationcontrollers", func(action clientgotesting.Action) (handled bool, ret runtime.Object, err error) {
+		return true, &existingDeployments.Items[0], nil
+	})
+	kubeClient.PrependReactor("update", "replicationcontrollers", func(action clientgotesting.Action) (handled bool, ret runtime.Object, err error) {
+		attempts++
+		if attempts < 2 {
+			return true, nil, kerrors.NewConflict(corev1.Resource("replicationcontrollers"), existingDeployment.Name, fmt.Errorf("conflict"))
+		}
+		return true, action.(clientgotesting.UpdateAction).GetObject(), nil
+	})
+
+	o := &DeployOptions{kubeClient: kubeClient, IOStreams: genericclioptions.NewTestIOStreamsDiscard()}
+	if err := o.cancel(config); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if attempts != 2 {
+		t.Fatalf("expected 2 update attempts, got %d", attempts)
+	}
+}
+
+// TestDeploy_reenableTriggersConflictRetried mirrors the above for
+// reenableTriggers.
+func TestDeploy_reenableTriggersConflictRetried(t *testing.T) {
+	mktrigger := func() appsv1.DeploymentTriggerPolicy {
+		t := appstest.OkImageChangeTrigger()
+		t.ImageChangeParams.Automatic = false
+		return t
+	}
+
+	config := appstest.OkDeploymentConfig(1)
+	config.Spec.Triggers = []appsv1.DeploymentTriggerPolicy{mktrigger()}
+
+	attempts := 0
+	osClient := &appsfake.Clientset{}
+	osClient.AddReactor("get", "deploymentconfigs", func(action clientgotesting.Action) (handled bool, ret runtime.Object, err error) {
+		return true, config, nil
+	})
+	osClient.AddReactor("update", "deploymentconfigs", func(action clientgotesting.Action) (handled bool, ret runtime.Object, err error) {
+		attempts++
+		if attempts < 2 {
+			return true, nil, kerrors.NewConflict(schema.GroupResource{Group: "apps.openshift.io", Resource: "deploymentconfigs"}, config.Name, fmt.Errorf("conflict"))
+		}
+		return true, action.(clientgotesting.UpdateAction).GetObject(), nil
+	})
+
+	o := &DeployOptions{appsClient: osClient.Apps(), IOStreams: genericclioptions.NewTestIOStreamsDiscard()}
+	if err := o.reenableTriggers(config); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if attempts != 2 {
+		t.Fatalf("expected 2 update attempts, got %d", attempts)
+	}
+	if !config.Spec.Triggers[0].ImageChangeParams.Automatic {
+		t.Fatalf("expected trigger to be re-enabled after retry")
+	}
+}
+
+func withPreHook(config *appsv1.DeploymentConfig) *appsv1.DeploymentConfig {
+	config.Spec.Strategy.RecreateParams = &appsv1.RecreateDeploymentStrategyParams{
+		Pre: &appsv1.LifecycleHook{
+			FailurePolicy: appsv1.LifecycleHookFailurePolicyAbort,
+			ExecNewPod: &appsv1.ExecNewPodHook{
+				ContainerName: config.Spec.Template.Spec.Containers[0].Name,
+				Command:       []string{"/bin/true"},
+			},
+		},
+	}
+	return config
+}
+
+// TestCmdDeploy_latestWithPreHookOk ensures that deploy runs a config's
+// pre-deployment hook, creating and watching a hook pod, before reporting
+// success.
+func TestCmdDeploy_latestWithPreHookOk(t *testing.T) {
+	config := withPreHook(appstest.OkDeploymentConfig(1))
+	updatedConfig := config
+
+	var createdPod *corev1.Pod
+
+	osClient := &appsfake.Clientset{}
+	osClient.PrependReactor("create", "deploymentconfigs", func(action clientgotesting.Action) (handled bool, ret runtime.Object, err error) {
+		if action.GetSubresource() != "instantiate" {
+			return false, nil, nil
+		}
+		updatedConfig.Status.LatestVersion++
+		return true, updatedConfig, nil
+	})
+
+	kubeClient := kubefake.NewSimpleClientset()
+	kubeClient.PrependReactor("get", "replicationcontrollers", func(action clientgotesting.Action) (handled bool, ret runtime.Object, err error) {
+		return true, nil, kerrors.NewNotFound(corev1.Resource("replicationcontrollers"), action.(clientgotesting.GetAction).GetName())
+	})
+	kubeClient.PrependReactor("create", "pods", func(action clientgotesting.Action) (handled bool, ret runtime.Object, err error) {
+		createdPod = action.(clientgotesting.CreateAction).GetObject().(*corev1.Pod)
+		return true, createdPod, nil
+	})
+	kubeClient.PrependWatchReactor("pods", func(action clientgotesting.Action) (handled bool, ret watch.Interface, err error) {
+		w := watch.NewFake()
+		go func() {
+			w.Add(&corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: createdPod.Name},
+				Status:     corev1.PodStatus{Phase: corev1.PodSucceeded},
+			})
+		}()
+		return true, w, nil
+	})
+
+	o := &DeployOptions{appsClient: osClient.Apps(), kubeClient: kubeClient, IOStreams: genericclioptions.NewTestIOStreamsDiscard()}
+	if err := o.deploy(config); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if createdPod == nil {
+		t.Fatalf("expected a hook pod to be created")
+	}
+	if e, a := "lifecycle", createdPod.Spec.Containers[0].Name; e != a {
+		t.Fatalf("expected hook container named %q, got %q", e, a)
+	}
+}
+
+// TestCmdDeploy_latestWithPreHookFailure ensures that deploy surfaces the
+// hook pod's failure message when the pre-deployment hook fails.
+func TestCmdDeploy_latestWithPreHookFailure(t *testing.T) {
+	config := withPreHook(appstest.OkDeploymentConfig(1))
+	updatedConfig := config
+
+	var createdPod *corev1.Pod
+
+	osClient := &appsfake.Clientset{}
+	osClient.PrependReactor("create", "deploymentconfigs", func(action clientgotesting.Action) (handled bool, ret runtime.Object, err error) {
+		if action.GetSubresource() != "instantiate" {
+			return false, nil, nil
+		}
+		updatedConfig.Status.LatestVersion++
+		return true, updatedConfig, nil
+	})
+
+	kubeClient := kubefake.NewSimpleClientset()
+	kubeClient.PrependReactor("get", "replicationcontrollers", func(action clientgotesting.Action) (handled bool, ret runtime.Object, err error) {
+		return true, nil, kerrors.NewNotFound(corev1.Resource("replicationcontrollers"), action.(clientgotesting.GetAction).GetName())
+	})
+	kubeClient.PrependReactor("create", "pods", func(action clientgotesting.Action) (handled bool, ret runtime.Object, err error) {
+		createdPod = action.(clientgotesting.CreateAction).GetObject().(*corev1.Pod)
+		return true, createdPod, nil
+	})
+	kubeClient.PrependWatchReactor("pods", func(action clientgotesting.Action) (handled bool, ret watch.Interface, err error) {
+		w := watch.NewFake()
+		go func() {
+			w.Modify(&corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: createdPod.Name},
+				Status:     corev1.PodStatus{Phase: corev1.PodFailed, Message: "hook command exited 1"},
+			})
+		}()
+		return true, w, nil
+	})
+
+	o := &DeployOptions{appsClient: osClient.Apps(), kubeClient: kubeClient, IOStreams: genericclioptions.NewTestIOStreamsDiscard()}
+	err := o.deploy(config)
+	if err == nil {
+		t.Fatal("expected an error from the failed pre-hook")
+	}
+	if !strings.Contains(err.Error(), "hook command exited 1") {
+		t.Fatalf("expected error to contain the hook pod's status message, got: %v", err)
+	}
+}
+
+// TestDeploymentConfigDescriber_Describe ensures that Describe returns
+// revisions sorted oldest to newest and marks the latest complete revision
+// as active.
+func TestDeploymentConfigDescriber_Describe(t *testing.T) {
+	config := appstest.OkDeploymentConfig(3)
+
+	existingDeployments := &corev1.ReplicationControllerList{}
+	for _, v := range []struct {
+		version int64
+		status  appsutil.DeploymentStatus
+	}{
+		{1, appsutil.DeploymentStatusComplete},
+		{2, appsutil.DeploymentStatusFailed},
+		{3, appsutil.DeploymentStatusComplete},
+	} {
+		d := deploymentFor(appstest.OkDeploymentConfig(v.version), v.status)
+		existingDeployments.Items = append(existingDeployments.Items, *d)
+	}
+
+	kubeClient := kubefake.NewSimpleClientset()
+	kubeClient.PrependReactor("list", "replicationcontrollers", func(action clientgotesting.Action) (handled bool, ret runtime.Object, err error) {
+		return true, existingDeployments, nil
+	})
+	kubeClient.PrependReactor("list", "pods", func(action clientgotesting.Action) (handled bool, ret runtime.Object, err error) {
+		return true, &corev1.PodList{}, nil
+	})
+
+	history, err := NewDeploymentConfigDescriber(kubeClient).Describe(config)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if e, a := 3, len(history.Items); e != a {
+		t.Fatalf("expected %d revisions, got %d", e, a)
+	}
+	for i, entry := range history.Items {
+		if e, a := int64(i+1), entry.Revision; e != a {
+			t.Fatalf("expected revisions sorted ascending, item %d was revision %d", i, a)
+		}
+	}
+
+	active := -1
+	for i, entry := range history.Items {
+		if entry.Active {
+			active = i
+		}
+	}
+	if active == -1 {
+		t.Fatal("expected one revision to be marked active")
+	}
+	if e, a := int64(3), history.Items[active].Revision; e != a {
+		t.Fatalf("expected revision #3 to be active, got #%d", a)
+	}
+}
+func hookDeploymentAndHook(t *testing.T) (*corev1.ReplicationController, *appsv1.LifecycleHook) {
+	t.Helper()
+	config := appstest.OkDeploymentConfig(1)
+	deployment, err := appsutil.MakeDeployment(config)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	hook := &appsv1.LifecycleHook{
+		ExecNewPod: &appsv1.ExecNewPodHook{
+			ContainerName: config.Spec.Template.Spec.Containers[0].Name,
+			Command:       []string{"/bin/true"},
+		},
+	}
+	return deployment, hook
+}
+
+// TestHookExecutor_AttachToCompletedPod ensures that attaching to a
+// pre-existing hook pod that is already in a terminal phase returns
+// immediately instead of waiting on a watch that will never deliver another
+// event for it.
+func TestHookExecutor_AttachToCompletedPod(t *testing.T) {
+	deployment, hook := hookDeploymentAndHook(t)
+	existingPod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: deployment.Name + "-pre", Namespace: deployment.Namespace},
+		Status:     corev1.PodStatus{Phase: corev1.PodSucceeded},
+	}
+
+	kubeClient := kubefake.NewSimpleClientset()
+	kubeClient.PrependReactor("create", "pods", func(action clientgotesting.Action) (handled bool, ret runtime.Object, err error) {
+		return true, nil, kerrors.NewAlreadyExists(corev1.Resource("pods"), existingPod.Name)
+	})
+	kubeClient.PrependReactor("get", "pods", func(action clientgotesting.Action) (handled bool, ret runtime.Object, err error) {
+		return true, existingPod, nil
+	})
+	kubeClient.PrependWatchReactor("pods", func(action clientgotesting.Action) (handled bool, ret watch.Interface, err error) {
+		t.Fatal("attaching to an already-terminal pod must not start a watch")
+		return false, nil, nil
+	})
+
+	executor := NewHookExecutor(kubeClient, genericclioptions.NewTestIOStreamsDiscard().Out)
+	if err := executor.Execute(hook, deployment, "pre"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// TestHookExecutor_WatchClosedIsError ensures that a watch which closes
+// before the hook pod reaches a terminal phase is surfaced as an error
+// rather than treated as success.
+func TestHookExecutor_WatchClosedIsError(t *testing.T) {
+	deployment, hook := hookDeploymentAndHook(t)
+
+	kubeClient := kubefake.NewSimpleClientset()
+	kubeClient.PrependReactor("create", "pods", func(action clientgotesting.Action) (handled bool, ret runtime.Object, err error) {
+		return true, action.(clientgotesting.CreateAction).GetObject(), nil
+	})
+	kubeClient.PrependWatchReactor("pods", func(action clientgotesting.Action) (handled bool, ret watch.Interface, err error) {
+		w := watch.NewFake()
+		go w.Stop()
+		return true, w, nil
+	})
+
+	executor := NewHookExecutor(kubeClient, genericclioptions.NewTestIOStreamsDiscard().Out)
+	err := executor.Execute(hook, deployment, "pre")
+	if err == nil {
+		t.Fatal("expected an error when the watch closes before a terminal phase")
+	}
+}
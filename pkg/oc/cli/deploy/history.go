@@ -0,0 +1,182 @@
+package deploy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+
+	"sigs.k8s.io/yaml"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+
+	appsv1 "github.com/openshift/api/apps/v1"
+	appsutil "github.com/openshift/origin/pkg/apps/util"
+)
+
+// DeploymentHistoryEntry describes a single revision of a DeploymentConfig,
+// as reported by `oc deploy --history`. It is a printer type local to this
+// command, not a Kubernetes API object.
+type DeploymentHistoryEntry struct {
+	Revision        int64       `json:"revision"`
+	Status          string      `json:"status"`
+	Active          bool        `json:"active"`
+	CreatedAt       metav1.Time `json:"createdAt"`
+	DesiredReplicas int32       `json:"desiredReplicas"`
+	CurrentReplicas int32       `json:"currentReplicas"`
+	Running         int32       `json:"running"`
+	Waiting         int32       `json:"waiting"`
+	Succeeded       int32       `json:"succeeded"`
+	Failed          int32       `json:"failed"`
+	CancelledReason string      `json:"cancelledReason,omitempty"`
+}
+
+// DeploymentHistory is the ordered revision history for a DeploymentConfig,
+// as printed by `oc deploy --history`.
+type DeploymentHistory struct {
+	Name  string                   `json:"name"`
+	Items []DeploymentHistoryEntry `json:"items"`
+}
+
+// DeploymentConfigDescriber builds a DeploymentHistory for a DeploymentConfig
+// by inspecting the ReplicationControllers and pods it owns.
+type DeploymentConfigDescriber struct {
+	kubeClient kubernetes.Interface
+}
+
+// NewDeploymentConfigDescriber returns a DeploymentConfigDescriber backed by
+// kubeClient.
+func NewDeploymentConfigDescriber(kubeClient kubernetes.Interface) *DeploymentConfigDescriber {
+	return &DeploymentConfigDescriber{kubeClient: kubeClient}
+}
+
+// Describe returns the revision history for config, sorted oldest to newest,
+// with the active deployment (the latest non-failed complete revision)
+// marked.
+func (d *DeploymentConfigDescriber) Describe(config *appsv1.DeploymentConfig) (*DeploymentHistory, error) {
+	deployments, err := d.kubeClient.CoreV1().ReplicationControllers(config.Namespace).List(metav1.ListOptions{
+		LabelSelector: appsutil.ConfigSelector(config.Name).String(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]corev1.ReplicationController, len(deployments.Items))
+	copy(items, deployments.Items)
+	sort.Slice(items, func(i, j int) bool {
+		return appsutil.DeploymentVersionFor(&items[i]) < appsutil.DeploymentVersionFor(&items[j])
+	})
+
+	activeVersion := int64(-1)
+	for i := range items {
+		status := appsutil.DeploymentStatusFor(&items[i])
+		if status == appsutil.DeploymentStatusComplete {
+			activeVersion = appsutil.DeploymentVersionFor(&items[i])
+		}
+	}
+
+	history := &DeploymentHistory{Name: config.Name}
+	for i := range items {
+		entry, err := d.entryFor(&items[i])
+		if err != nil {
+			return nil, err
+		}
+		entry.Active = activeVersion >= 0 && entry.Revision == activeVersion
+		history.Items = append(history.Items, entry)
+	}
+	return history, nil
+}
+
+func (d *DeploymentConfigDescriber) entryFor(deployment *corev1.ReplicationController) (DeploymentHistoryEntry, error) {
+	entry := DeploymentHistoryEntry{
+		Revision:        appsutil.DeploymentVersionFor(deployment),
+		Status:          string(appsutil.DeploymentStatusFor(deployment)),
+		CreatedAt:       deployment.CreationTimestamp,
+		CancelledReason: appsutil.DeploymentStatusReasonFor(deployment),
+	}
+	if deployment.Spec.Replicas != nil {
+		entry.DesiredReplicas = *deployment.Spec.Replicas
+	}
+	entry.CurrentReplicas = deployment.Status.Replicas
+
+	pods, err := d.kubeClient.CoreV1().Pods(deployment.Namespace).List(metav1.ListOptions{
+		LabelSelector: appsutil.DeployerPodSelector(deployment.Name).String(),
+	})
+	if err != nil {
+		return entry, err
+	}
+	targetPods, err := d.kubeClient.CoreV1().Pods(deployment.Namespace).List(metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(deployment.Spec.Selector).String(),
+	})
+	if err != nil {
+		return entry, err
+	}
+
+	countPhases(&entry, pods.Items)
+	countPhases(&entry, targetPods.Items)
+	return entry, nil
+}
+
+func countPhases(entry *DeploymentHistoryEntry, pods []corev1.Pod) {
+	for _, pod := range pods {
+		switch pod.Status.Phase {
+		case corev1.PodRunning:
+			entry.Running++
+		case corev1.PodPending:
+			entry.Waiting++
+		case corev1.PodSucceeded:
+			entry.Succeeded++
+		case corev1.PodFailed:
+			entry.Failed++
+		}
+	}
+}
+
+// history prints config's revision history to o.Out, as a table by default
+// or as JSON/YAML when o.Output is set.
+func (o *DeployOptions) history(config *appsv1.DeploymentConfig) error {
+	history, err := NewDeploymentConfigDescriber(o.kubeClient).Describe(config)
+	if err != nil {
+		return err
+	}
+
+	switch o.Output {
+	case "json":
+		data, err := json.MarshalIndent(history, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = o.Out.Write(append(data, '\n'))
+		return err
+	case "yaml":
+		data, err := yaml.Marshal(history)
+		if err != nil {
+			return err
+		}
+		_, err = o.Out.Write(data)
+		return err
+	case "":
+		return printHistoryTable(o.Out, history)
+	default:
+		return fmt.Errorf("unsupported output format %q", o.Output)
+	}
+}
+
+func printHistoryTable(out io.Writer, history *DeploymentHistory) error {
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "REVISION\tSTATUS\tCREATED\tDESIRED\tCURRENT\tRUNNING\tWAITING\tSUCCEEDED\tFAILED\tREASON")
+	for _, entry := range history.Items {
+		name := fmt.Sprintf("%d", entry.Revision)
+		if entry.Active {
+			name = fmt.Sprintf("%d (latest)", entry.Revision)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\t%d\t%d\t%d\t%d\t%s\n",
+			name, entry.Status, entry.CreatedAt.Time, entry.DesiredReplicas, entry.CurrentReplicas,
+			entry.Running, entry.Waiting, entry.Succeeded, entry.Failed, entry.CancelledReason)
+	}
+	return w.Flush()
+}
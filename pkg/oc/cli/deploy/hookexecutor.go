@@ -0,0 +1,200 @@
+package deploy
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+
+	appsv1 "github.com/openshift/api/apps/v1"
+	appsutil "github.com/openshift/origin/pkg/apps/util"
+)
+
+// logStreamRetryInterval is how long streamLogsUntilReady waits between
+// attempts to open a still-Pending hook pod's logs.
+const logStreamRetryInterval = time.Second
+
+// HookExecutor runs an ExecNewPod lifecycle hook for a deployment, streaming
+// the hook pod's logs to Out and returning once the pod reaches a terminal
+// phase.
+type HookExecutor struct {
+	kubeClient kubernetes.Interface
+	Out        io.Writer
+}
+
+// NewHookExecutor returns a HookExecutor that creates hook pods with
+// kubeClient and streams their logs to out.
+func NewHookExecutor(kubeClient kubernetes.Interface, out io.Writer) *HookExecutor {
+	return &HookExecutor{kubeClient: kubeClient, Out: out}
+}
+
+// Execute runs hook against deployment, naming the hook pod
+// "<deployment>-<suffix>". If a pod with that name already exists, the
+// executor attaches to it instead of creating a new one.
+func (e *HookExecutor) Execute(hook *appsv1.LifecycleHook, deployment *corev1.ReplicationController, suffix string) error {
+	if hook == nil || hook.ExecNewPod == nil {
+		return nil
+	}
+
+	pod, err := e.makeHookPod(hook.ExecNewPod, deployment, suffix)
+	if err != nil {
+		return err
+	}
+
+	attached := false
+	created, err := e.kubeClient.CoreV1().Pods(deployment.Namespace).Create(pod)
+	switch {
+	case err == nil:
+		pod = created
+	case kerrors.IsAlreadyExists(err):
+		pod, err = e.kubeClient.CoreV1().Pods(deployment.Namespace).Get(pod.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		attached = true
+	default:
+		return err
+	}
+
+	fmt.Fprintf(e.Out, "--> %s: Running hook pod %s ...\n", appsutil.LabelForDeployment(deployment), pod.Name)
+
+	if attached {
+		// The pod already existed; it may already be done, in which case
+		// there won't be any further watch events to tell us so.
+		switch pod.Status.Phase {
+		case corev1.PodSucceeded:
+			return nil
+		case corev1.PodFailed:
+			return fmt.Errorf("hook failed: %s", pod.Status.Message)
+		}
+	}
+
+	return e.waitForCompletion(pod)
+}
+
+// makeHookPod builds the hook pod for hook, copying env, working directory,
+// and resources from the container named by hook.ContainerName. Env entries
+// defined on the hook take precedence over the container's own env.
+func (e *HookExecutor) makeHookPod(hook *appsv1.ExecNewPodHook, deployment *corev1.ReplicationController, suffix string) (*corev1.Pod, error) {
+	var container *corev1.Container
+	for i := range deployment.Spec.Template.Spec.Containers {
+		if deployment.Spec.Template.Spec.Containers[i].Name == hook.ContainerName {
+			container = &deployment.Spec.Template.Spec.Containers[i]
+			break
+		}
+	}
+	if container == nil {
+		return nil, fmt.Errorf("no container named %q found in deployment %s", hook.ContainerName, deployment.Name)
+	}
+
+	env := map[string]corev1.EnvVar{}
+	for _, ev := range container.Env {
+		env[ev.Name] = ev
+	}
+	for _, ev := range hook.Env {
+		env[ev.Name] = ev
+	}
+	mergedEnv := make([]corev1.EnvVar, 0, len(env))
+	for _, ev := range env {
+		mergedEnv = append(mergedEnv, ev)
+	}
+
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%s-%s", deployment.Name, suffix),
+			Namespace: deployment.Namespace,
+			Labels: map[string]string{
+				appsutil.DeployerPodForDeploymentLabel: deployment.Name,
+			},
+		},
+		Spec: corev1.PodSpec{
+			RestartPolicy: corev1.RestartPolicyNever,
+			Volumes:       deployment.Spec.Template.Spec.Volumes,
+			Containers: []corev1.Container{
+				{
+					Name:         "lifecycle",
+					Image:        container.Image,
+					Command:      hook.Command,
+					WorkingDir:   container.WorkingDir,
+					Env:          mergedEnv,
+					Resources:    container.Resources,
+					VolumeMounts: container.VolumeMounts,
+				},
+			},
+		},
+	}, nil
+}
+
+// waitForCompletion streams pod's logs and blocks until it reaches a
+// terminal phase, returning an error containing pod.Status.Message on
+// PodFailed.
+func (e *HookExecutor) waitForCompletion(pod *corev1.Pod) error {
+	w, err := e.kubeClient.CoreV1().Pods(pod.Namespace).Watch(metav1.ListOptions{
+		FieldSelector:   fmt.Sprintf("metadata.name=%s", pod.Name),
+		ResourceVersion: pod.ResourceVersion,
+	})
+	if err != nil {
+		return err
+	}
+	defer w.Stop()
+
+	// The pod is typically still Pending here, so opening its logs would
+	// just fail; retry in the background until the container starts, rather
+	// than giving up after a single attempt made before there's anything to
+	// stream.
+	stopLogs := make(chan struct{})
+	defer close(stopLogs)
+	go e.streamLogsUntilReady(pod, stopLogs)
+
+	for event := range w.ResultChan() {
+		if event.Type == watch.Error {
+			return fmt.Errorf("error watching hook pod %s", pod.Name)
+		}
+		p, ok := event.Object.(*corev1.Pod)
+		if !ok {
+			continue
+		}
+		switch p.Status.Phase {
+		case corev1.PodSucceeded:
+			return nil
+		case corev1.PodFailed:
+			return fmt.Errorf("hook failed: %s", p.Status.Message)
+		}
+	}
+	return fmt.Errorf("lost watch on hook pod %s before it reached a terminal phase", pod.Name)
+}
+
+// streamLogsUntilReady calls streamLogs, retrying on an interval until it
+// succeeds or stopCh is closed. Opening logs on a Pending pod fails
+// immediately, so this is what lets the hook's output reach Out once its
+// container actually starts instead of only when it happens to already be
+// running.
+func (e *HookExecutor) streamLogsUntilReady(pod *corev1.Pod, stopCh <-chan struct{}) {
+	for {
+		if e.streamLogs(pod) {
+			return
+		}
+		select {
+		case <-stopCh:
+			return
+		case <-time.After(logStreamRetryInterval):
+		}
+	}
+}
+
+// streamLogs copies pod's logs to Out, returning false if they couldn't yet
+// be opened (e.g. the container hasn't started) so the caller can retry.
+func (e *HookExecutor) streamLogs(pod *corev1.Pod) bool {
+	logs, err := e.kubeClient.CoreV1().Pods(pod.Namespace).GetLogs(pod.Name, &corev1.PodLogOptions{Follow: true}).Stream()
+	if err != nil {
+		return false
+	}
+	defer logs.Close()
+	io.Copy(e.Out, logs)
+	return true
+}